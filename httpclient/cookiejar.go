@@ -0,0 +1,205 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// persistentJar wraps a standard cookiejar.Jar with a disk-backed
+// cache keyed by eTLD+1, so a crawler doesn't have to re-login or
+// re-solve challenges on every run.
+type persistentJar struct {
+	jar  *cookiejar.Jar
+	path string
+
+	mu      sync.Mutex
+	cookies map[string][]*http.Cookie
+	dirty   bool
+}
+
+func newPersistentJar(path string, policy cookiejar.PublicSuffixList) (*persistentJar, error) {
+	if policy == nil {
+		policy = publicsuffix.List
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: policy})
+	if err != nil {
+		return nil, err
+	}
+	return &persistentJar{
+		jar:     jar,
+		path:    path,
+		cookies: make(map[string][]*http.Cookie),
+	}, nil
+}
+
+// SetCookies implements http.CookieJar.
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	if len(cookies) == 0 {
+		return
+	}
+	key := siteKey(u.Hostname())
+	j.mu.Lock()
+	j.cookies[key] = mergeCookies(j.cookies[key], cookies)
+	if len(j.cookies[key]) == 0 {
+		delete(j.cookies, key)
+	}
+	j.dirty = true
+	j.mu.Unlock()
+}
+
+// Cookies implements http.CookieJar.
+func (j *persistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func siteKey(host string) string {
+	// EffectiveTLDPlusOne has no concept of IP literals and happily
+	// returns a bogus, nil-error result for one (e.g. "127.0.0.1" ->
+	// "0.1"), so check for that case first.
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	key, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return key
+}
+
+func mergeCookies(existing, updates []*http.Cookie) []*http.Cookie {
+	out := append([]*http.Cookie(nil), existing...)
+	for _, u := range updates {
+		idx := -1
+		for i, e := range out {
+			if e.Name == u.Name && e.Domain == u.Domain && e.Path == u.Path {
+				idx = i
+				break
+			}
+		}
+		if isExpired(u) {
+			if idx >= 0 {
+				out = append(out[:idx], out[idx+1:]...)
+			}
+			continue
+		}
+		if idx >= 0 {
+			out[idx] = u
+		} else {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func isExpired(c *http.Cookie) bool {
+	if c.MaxAge < 0 {
+		return true
+	}
+	return !c.Expires.IsZero() && c.Expires.Before(time.Now())
+}
+
+// load replaces the in-memory cookies with the contents of path. A
+// missing file is not an error: it just means there is nothing to
+// load yet.
+func (j *persistentJar) load() error {
+	if j.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cookies := make(map[string][]*http.Cookie)
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.cookies = cookies
+	j.mu.Unlock()
+	for host, cs := range cookies {
+		j.jar.SetCookies(&url.URL{Scheme: "https", Host: host}, cs)
+	}
+	return nil
+}
+
+// save atomically rewrites path with the in-memory cookies, via a
+// temp file plus rename so a crash or concurrent reader never sees a
+// partially written file.
+//
+// The whole marshal-write-rename sequence runs under mu, not just the
+// snapshot at the start: releasing the lock for the file I/O and
+// re-acquiring it afterwards to clear dirty lets a slow writer clobber
+// a newer, more complete save and then still clear the dirty bit,
+// losing whichever cookies were set in between. Holding the lock
+// throughout serializes concurrent savers against each other and
+// against SetCookies, so the data written always matches the dirty
+// flag being cleared.
+func (j *persistentJar) save() error {
+	if j.path == "" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.dirty {
+		return nil
+	}
+	data, err := json.Marshal(j.cookies)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".cookies-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, j.path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	j.dirty = false
+	return nil
+}
+
+// purgeExpired drops cookies past their Expires/MaxAge from the set
+// that the next save writes to disk.
+func (j *persistentJar) purgeExpired() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for key, cs := range j.cookies {
+		kept := cs[:0]
+		for _, c := range cs {
+			if !isExpired(c) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			delete(j.cookies, key)
+		} else {
+			j.cookies[key] = kept
+		}
+	}
+	j.dirty = true
+}
@@ -12,6 +12,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 // Client is a simple wrap of http client.
@@ -21,6 +23,22 @@ type Client struct {
 
 	Timeout time.Duration
 	UA      string
+
+	// AcceptStatus reports whether an HTTP status code should be
+	// treated as success. Defaults to the 2xx range.
+	AcceptStatus func(int) bool
+	// ErrBodyLimit caps how many bytes of a failed response's body
+	// are captured into the returned *HTTPError. Defaults to 64KiB.
+	ErrBodyLimit int64
+	// MaxResponseBytes caps how many bytes JSON, JSONStream, WriteTo,
+	// and Bytes will read from a response body. Zero means unbounded.
+	// Exceeding it returns ErrResponseTooLarge.
+	MaxResponseBytes int64
+
+	// jar is set when the client was created with a CookieJarPath or
+	// CookieJarPolicy, so SaveCookies/LoadCookies/PurgeExpired have
+	// something to act on.
+	jar *persistentJar
 }
 
 // A Request represents an HTTP request received by a server
@@ -31,6 +49,14 @@ type Request struct {
 	Err    error
 	Cancel func()
 	Client *Client
+
+	// bodyCancel, when set, is started from Invoke right before the
+	// request is sent. It exists for streaming bodies (PostMultipart)
+	// that need to watch the request's context to release resources
+	// on cancellation; wiring it up this late lets it observe
+	// whatever context chained calls like WithTimeout settle on,
+	// instead of racing them.
+	bodyCancel func()
 }
 
 // Response represents the response from an HTTP request.
@@ -48,15 +74,117 @@ func (req *Request) Invoke() *Response {
 	if req.Err != nil {
 		return &Response{Request: req, Err: req.Err}
 	}
+	if req.bodyCancel != nil {
+		req.bodyCancel()
+	}
 	rsp, err := req.Client.client.Do(req.Request)
 	if err != nil {
 		return &Response{Request: req, Err: err}
 	}
+	if jar := req.Client.jar; jar != nil && len(rsp.Header["Set-Cookie"]) > 0 {
+		jar.save()
+	}
 	return &Response{Response: rsp, Request: req}
 }
 
+// SaveCookies writes the client's cookies to Options.CookieJarPath,
+// replacing its previous contents. It is a no-op if the client was
+// not created with CookieJarPath set.
+func (c *Client) SaveCookies() error {
+	if c.jar == nil {
+		return nil
+	}
+	return c.jar.save()
+}
+
+// LoadCookies re-reads cookies from Options.CookieJarPath, adding
+// them to the cookies the client sends. The set that the next
+// SaveCookies writes is replaced by the file's contents. It is a
+// no-op if the client was not created with CookieJarPath set.
+func (c *Client) LoadCookies() error {
+	if c.jar == nil {
+		return nil
+	}
+	return c.jar.load()
+}
+
+// PurgeExpired drops cookies past their Expires/MaxAge from the set
+// that the next SaveCookies writes to disk. It is a no-op if the
+// client was not created with CookieJarPath set.
+func (c *Client) PurgeExpired() {
+	if c.jar == nil {
+		return
+	}
+	c.jar.purgeExpired()
+}
+
+// WithHeader sets a single request header, overwriting any existing
+// values for key. It is a no-op when the request is already in an
+// error state.
+func (req *Request) WithHeader(key, value string) *Request {
+	if req.Err != nil {
+		return req
+	}
+	req.Header.Set(key, value)
+	return req
+}
+
+// WithHeaders merges h into the request headers, overwriting any
+// existing values for the same keys. It is a no-op when the request
+// is already in an error state.
+func (req *Request) WithHeaders(h http.Header) *Request {
+	if req.Err != nil {
+		return req
+	}
+	for k, v := range h {
+		req.Header.Del(k)
+		for _, vv := range v {
+			req.Header.Add(k, vv)
+		}
+	}
+	return req
+}
+
+// WithTimeout replaces the request's timeout with d. The current
+// context is canceled and a new one is built from
+// context.Background() so Cancel keeps releasing whichever context is
+// active. It is a no-op when the
+// request is already in an error state.
+func (req *Request) WithTimeout(d time.Duration) *Request {
+	if req.Err != nil {
+		return req
+	}
+	req.Cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	req.Request = req.Request.Clone(ctx)
+	req.Cancel = cancel
+	return req
+}
+
+// WithCookie adds a cookie to the request. It is a no-op when the
+// request is already in an error state.
+func (req *Request) WithCookie(c *http.Cookie) *Request {
+	if req.Err != nil {
+		return req
+	}
+	req.AddCookie(c)
+	return req
+}
+
+// WithBasicAuth sets the request's Authorization header to use HTTP
+// Basic Authentication with user and pass. It is a no-op when the
+// request is already in an error state.
+func (req *Request) WithBasicAuth(user, pass string) *Request {
+	if req.Err != nil {
+		return req
+	}
+	req.SetBasicAuth(user, pass)
+	return req
+}
+
 // Drop drop the response body and return a nil.
-// It also returns error if HTTP response status code is not 200.
+// It also returns a *HTTPError if the response status code fails
+// StatusOK.
 func (rsp *Response) Drop() error {
 	if rsp.Err != nil {
 		return rsp.Err
@@ -67,7 +195,8 @@ func (rsp *Response) Drop() error {
 }
 
 // JSON unmarshal HTTP response body into input object.
-// It also returns error if HTTP response status code is not 200.
+// It also returns a *HTTPError if the response status code fails
+// StatusOK.
 func (rsp *Response) JSON(o interface{}) error {
 	if rsp.Err != nil {
 		return rsp.Err
@@ -77,12 +206,13 @@ func (rsp *Response) JSON(o interface{}) error {
 	if err := rsp.checkStatusCode(); err != nil {
 		return err
 	}
-	dec := json.NewDecoder(rsp.Body)
+	dec := json.NewDecoder(rsp.limitedBody())
 	return dec.Decode(o)
 }
 
 // WriteTo writes HTTP response body into a writer.
-// It also returns error if HTTP response status code is not 200.
+// It also returns a *HTTPError if the response status code fails
+// StatusOK.
 func (rsp *Response) WriteTo(w io.Writer) (int64, error) {
 	if rsp.Err != nil {
 		return 0, rsp.Err
@@ -92,15 +222,67 @@ func (rsp *Response) WriteTo(w io.Writer) (int64, error) {
 	if err := rsp.checkStatusCode(); err != nil {
 		return 0, err
 	}
-	return io.Copy(w, rsp.Body)
+	return io.Copy(w, rsp.limitedBody())
+}
+
+// StatusOK reports whether the response status code should be treated
+// as success. It uses the client's AcceptStatus hook when set, and
+// otherwise accepts the 2xx range.
+func (rsp *Response) StatusOK() bool {
+	if accept := rsp.Request.Client.AcceptStatus; accept != nil {
+		return accept(rsp.StatusCode)
+	}
+	return rsp.StatusCode >= 200 && rsp.StatusCode < 300
+}
+
+// JSONOrError decodes the response body into ok when StatusOK, or
+// into errObj otherwise. Either way it consumes and closes the body.
+func (rsp *Response) JSONOrError(ok, errObj interface{}) error {
+	if rsp.Err != nil {
+		return rsp.Err
+	}
+	defer rsp.Request.Cancel()
+	defer rsp.Body.Close()
+	if rsp.StatusOK() {
+		return json.NewDecoder(rsp.limitedBody()).Decode(ok)
+	}
+	return json.NewDecoder(rsp.limitedBody()).Decode(errObj)
 }
 
 func (rsp *Response) checkStatusCode() error {
-	if rsp.StatusCode != 200 {
-		data, _ := io.ReadAll(rsp.Body)
-		return fmt.Errorf("Response %d, body=%s", rsp.StatusCode, string(data))
+	if rsp.StatusOK() {
+		return nil
 	}
-	return nil
+	limit := rsp.Request.Client.ErrBodyLimit
+	if limit <= 0 {
+		limit = defaultErrBodyLimit
+	}
+	data, _ := io.ReadAll(io.LimitReader(rsp.limitedBody(), limit))
+	return &HTTPError{
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header,
+		Body:       data,
+		URL:        rsp.Request.URL.String(),
+	}
+}
+
+// defaultErrBodyLimit is how much of a failed response's body is
+// captured into an *HTTPError when Client.ErrBodyLimit is unset.
+const defaultErrBodyLimit = 64 * 1024
+
+// HTTPError reports a response whose status code failed the client's
+// StatusOK check. Body holds up to ErrBodyLimit bytes of the response
+// so callers can diagnose structured error envelopes without needing
+// a fresh request.
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	URL        string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("httpclient: %s: response %d, body=%s", e.URL, e.StatusCode, e.Body)
 }
 
 // Options for creating a Client.
@@ -114,6 +296,32 @@ type Options struct {
 	UA string
 	// Timeout of HTTP request.
 	Timeout time.Duration
+	// Retry enables automatic retries for transient failures such as
+	// network errors and 429/502/503/504 responses. Nil disables
+	// retrying.
+	Retry *Retry
+	// RateLimit throttles outgoing requests per host so multiple
+	// crawlers sharing a *Client don't hammer the same site. Nil
+	// disables rate limiting.
+	RateLimit *RateLimit
+	// AcceptStatus reports whether an HTTP status code should be
+	// treated as success. Defaults to the 2xx range.
+	AcceptStatus func(int) bool
+	// ErrBodyLimit caps how many bytes of a failed response's body
+	// are captured into the returned *HTTPError. Defaults to 64KiB.
+	ErrBodyLimit int64
+	// MaxResponseBytes caps how many bytes JSON, JSONStream, WriteTo,
+	// and Bytes will read from a response body. Zero means unbounded.
+	// Exceeding it returns ErrResponseTooLarge.
+	MaxResponseBytes int64
+	// CookieJarPath, when set, persists cookies to this file across
+	// runs: New loads it, and successful responses that change the
+	// jar rewrite it.
+	CookieJarPath string
+	// CookieJarPolicy controls which domains may see which cookies,
+	// mirroring net/http/cookiejar's PublicSuffixList option. A nil
+	// policy falls back to golang.org/x/net/publicsuffix.List.
+	CookieJarPolicy cookiejar.PublicSuffixList
 }
 
 // New creates a new Client with given option.
@@ -140,6 +348,38 @@ func New(o *Options) (*Client, error) {
 		if o.UA != "" {
 			c.UA = o.UA
 		}
+		if o.Retry != nil || o.RateLimit != nil {
+			rt := &retryTransport{next: c.client.Transport}
+			if o.Retry != nil {
+				rt.retry = *o.Retry
+			} else {
+				rt.retry = Retry{MaxAttempts: 1}
+			}
+			if o.RateLimit != nil {
+				rt.limiter = newHostLimiter(rate.Limit(o.RateLimit.Rate), o.RateLimit.Burst)
+			}
+			c.client.Transport = rt
+		}
+		if o.AcceptStatus != nil {
+			c.AcceptStatus = o.AcceptStatus
+		}
+		if o.ErrBodyLimit > 0 {
+			c.ErrBodyLimit = o.ErrBodyLimit
+		}
+		if o.MaxResponseBytes > 0 {
+			c.MaxResponseBytes = o.MaxResponseBytes
+		}
+		if o.CookieJarPath != "" || o.CookieJarPolicy != nil {
+			pj, err := newPersistentJar(o.CookieJarPath, o.CookieJarPolicy)
+			if err != nil {
+				return nil, err
+			}
+			if err := pj.load(); err != nil {
+				return nil, err
+			}
+			c.client.Jar = pj
+			c.jar = pj
+		}
 	}
 	return &c, nil
 }
@@ -155,7 +395,7 @@ func (c *Client) Get(url string) *Request {
 
 func (c *Client) makeRequest(method, url string, body io.Reader) *Request {
 	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		cancel()
 		return &Request{Err: err}
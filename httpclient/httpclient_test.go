@@ -2,10 +2,19 @@ package httpclient_test
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hanke0/subtitles-robot/httpclient"
 )
@@ -70,3 +79,589 @@ func TestWriteTo(t *testing.T) {
 		t.Fatalf("%s != %s", b.String(), "1\n")
 	}
 }
+
+func TestRequestWithHeader(t *testing.T) {
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Referer")
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	err = c.Get(s.URL).WithHeader("Referer", "https://example.com").Invoke().Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "https://example.com" {
+		t.Fatalf("Referer = %q, want %q", got, "https://example.com")
+	}
+}
+
+func TestRequestWithTimeout(t *testing.T) {
+	c, err := httpclient.New(&httpclient.Options{Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	err = c.Get(s.URL).WithTimeout(time.Second).Invoke().Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestErrShortCircuit(t *testing.T) {
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := c.PostJSON("http://example.com", make(chan int))
+	if req.Err == nil {
+		t.Fatal("expected Err to be set for an unmarshalable body")
+	}
+	wantErr := req.Err
+	req = req.WithHeader("X-Test", "1").
+		WithHeaders(http.Header{"X-Other": []string{"2"}}).
+		WithTimeout(time.Second).
+		WithCookie(&http.Cookie{Name: "a", Value: "b"}).
+		WithBasicAuth("u", "p")
+	if req.Err != wantErr {
+		t.Fatalf("Err changed after chaining on an error request: %v", req.Err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(&httpclient.Options{
+		Retry: &httpclient.Retry{
+			MaxAttempts:    3,
+			InitialBackoff: 5 * time.Millisecond,
+			MaxBackoff:     50 * time.Millisecond,
+			Multiplier:     2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = c.Get(s.URL).Invoke().Drop()
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+	if elapsed < 5*time.Millisecond {
+		t.Fatalf("expected backoff delay, took %s", elapsed)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(&httpclient.Options{
+		Retry: &httpclient.Retry{
+			MaxAttempts:    2,
+			InitialBackoff: time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = c.Get(s.URL).Invoke().Drop()
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("Retry-After should override the computed backoff, took %s", elapsed)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(&httpclient.Options{
+		RateLimit: &httpclient.RateLimit{Rate: 10, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 3
+	for i := 0; i < n; i++ {
+		if err := c.Get(s.URL).Invoke().Drop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != n {
+		t.Fatalf("got %d requests, want %d", len(times), n)
+	}
+	for i := 1; i < len(times); i++ {
+		gap := times[i].Sub(times[i-1])
+		if gap < 50*time.Millisecond {
+			t.Fatalf("request %d arrived only %s after request %d, want rate limiting to space them out", i, gap, i-1)
+		}
+	}
+}
+
+func TestRateLimitPerHostIsIndependent(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s1 := httptest.NewServer(mux)
+	defer s1.Close()
+	s2 := httptest.NewServer(mux)
+	defer s2.Close()
+	// hostLimiter keys by URL.Hostname(), so give the two servers
+	// distinct hostnames even though both listen on 127.0.0.1.
+	s2URL := strings.Replace(s2.URL, "127.0.0.1", "localhost", 1)
+
+	c, err := httpclient.New(&httpclient.Options{
+		RateLimit: &httpclient.RateLimit{Rate: 1, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(s1.URL).Invoke().Drop(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := c.Get(s2URL).Invoke().Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("a second host's burst was throttled by the first host's limiter, took %s", elapsed)
+	}
+}
+
+func TestStatusOKAcceptsCreated(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(s.URL).Invoke().Drop(); err != nil {
+		t.Fatalf("2xx status should be accepted by default: %v", err)
+	}
+}
+
+func TestHTTPErrorPreservesBody(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":1,"message":"nope"}`))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Get(s.URL).Invoke().Drop()
+	var httpErr *httpclient.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected *httpclient.HTTPError, got %T: %v", err, err)
+	}
+	if httpErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("StatusCode = %d, want %d", httpErr.StatusCode, http.StatusBadRequest)
+	}
+	if string(httpErr.Body) != `{"code":1,"message":"nope"}` {
+		t.Fatalf("Body = %q", httpErr.Body)
+	}
+}
+
+func TestJSONOrError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": 7, "message": "bad"})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ok obj
+	var errEnvelope struct {
+		Code    int
+		Message string
+	}
+	err = c.Get(s.URL).Invoke().JSONOrError(&ok, &errEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errEnvelope.Code != 7 || errEnvelope.Message != "bad" {
+		t.Fatalf("unexpected error envelope: %+v", errEnvelope)
+	}
+}
+
+func TestPostMultipart(t *testing.T) {
+	var (
+		gotMethod  string
+		gotField   string
+		gotName    string
+		gotContent string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm: %v", err)
+			return
+		}
+		gotField = r.FormValue("title")
+		f, fh, err := r.FormFile("subtitle")
+		if err != nil {
+			t.Errorf("FormFile: %v", err)
+			return
+		}
+		defer f.Close()
+		gotName = fh.Filename
+		data, _ := io.ReadAll(f)
+		gotContent = string(data)
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := url.Values{"title": []string{"My Movie"}}
+	files := []httpclient.FileField{
+		{
+			FieldName:   "subtitle",
+			FileName:    "movie.srt",
+			Content:     strings.NewReader("1\n00:00:00,000 --> 00:00:01,000\nHello\n"),
+			ContentType: "application/x-subrip",
+		},
+	}
+	if err := c.PostMultipart(s.URL, fields, files).Invoke().Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != http.MethodPost {
+		t.Fatalf("method = %q, want %q", gotMethod, http.MethodPost)
+	}
+	if gotField != "My Movie" {
+		t.Fatalf("title field = %q, want %q", gotField, "My Movie")
+	}
+	if gotName != "movie.srt" {
+		t.Fatalf("filename = %q, want %q", gotName, "movie.srt")
+	}
+	if gotContent != "1\n00:00:00,000 --> 00:00:01,000\nHello\n" {
+		t.Fatalf("file content = %q", gotContent)
+	}
+}
+
+func TestPostMultipartWithTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []httpclient.FileField{
+		{FieldName: "subtitle", FileName: "movie.srt", Content: strings.NewReader("hello")},
+	}
+	err = c.PostMultipart(s.URL, nil, files).WithTimeout(5 * time.Second).Invoke().Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostMultipartRetry(t *testing.T) {
+	var (
+		calls      int32
+		gotContent string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm: %v", err)
+			return
+		}
+		f, _, err := r.FormFile("subtitle")
+		if err != nil {
+			t.Errorf("FormFile: %v", err)
+			return
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		gotContent = string(data)
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := httpclient.New(&httpclient.Options{
+		Retry: &httpclient.Retry{
+			MaxAttempts:    2,
+			InitialBackoff: 5 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []httpclient.FileField{
+		{FieldName: "subtitle", FileName: "movie.srt", Content: strings.NewReader("hello")},
+	}
+	err = c.PostMultipart(s.URL, nil, files).Invoke().Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+	if gotContent != "hello" {
+		t.Fatalf("content = %q, want %q", gotContent, "hello")
+	}
+}
+
+func TestCookieJarRoundTrip(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123"})
+		json.NewEncoder(w).Encode(obj{})
+	})
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if err != nil || c.Value != "abc123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	c1, err := httpclient.New(&httpclient.Options{CookieJarPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Get(s.URL + "/set").Invoke().Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected cookie file to be written: %v", err)
+	}
+
+	c2, err := httpclient.New(&httpclient.Options{CookieJarPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Get(s.URL + "/check").Invoke().Drop(); err != nil {
+		t.Fatalf("cookies did not round-trip through disk: %v", err)
+	}
+}
+
+func TestCookieJarPurgeExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	c, err := httpclient.New(&httpclient.Options{CookieJarPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "stale", Value: "x", Expires: time.Now().Add(-time.Hour)})
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	if err := c.Get(s.URL).Invoke().Drop(); err != nil {
+		t.Fatal(err)
+	}
+	c.PurgeExpired()
+	if err := c.SaveCookies(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "stale") {
+		t.Fatalf("expired cookie was not purged: %s", data)
+	}
+}
+
+func TestCookieJarConcurrentWrites(t *testing.T) {
+	const n = 200
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	c, err := httpclient.New(&httpclient.Options{CookieJarPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "n" + r.URL.Query().Get("n"), Value: "v"})
+		json.NewEncoder(w).Encode(obj{})
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Get(s.URL + "?n=" + strconv.Itoa(i)).Invoke().Drop()
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected cookie file to exist: %v", err)
+	}
+	var saved map[string][]struct {
+		Name string
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("unmarshal cookie file: %v", err)
+	}
+	var got int
+	for _, cs := range saved {
+		got += len(cs)
+	}
+	if got != n {
+		t.Fatalf("persisted %d of %d concurrently set cookies", got, n)
+	}
+}
+
+func TestJSONStream(t *testing.T) {
+	s := runTestServer([]obj{{A: "1"}, {A: "2"}, {A: "3"}})
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = c.Get(s.URL).Invoke().JSONStream(func(raw json.RawMessage) error {
+		var o obj
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return err
+		}
+		got = append(got, o.A)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1", "2", "3"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	s := runTestServer(obj{A: strings.Repeat("x", 1000)})
+	defer s.Close()
+
+	c, err := httpclient.New(&httpclient.Options{MaxResponseBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var o obj
+	err = c.Get(s.URL).Invoke().JSON(&o)
+	if !errors.Is(err, httpclient.ErrResponseTooLarge) {
+		t.Fatalf("err = %v, want ErrResponseTooLarge", err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	s := runTestServer(obj{A: "hello"})
+	defer s.Close()
+
+	c, err := httpclient.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Get(s.URL).Invoke().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var o obj
+	if err := json.Unmarshal(data, &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.A != "hello" {
+		t.Fatalf("A = %q, want %q", o.A, "hello")
+	}
+}
@@ -0,0 +1,95 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrResponseTooLarge is returned by JSON, JSONStream, WriteTo, and
+// Bytes when a response body exceeds Client.MaxResponseBytes. It is
+// distinct from a truncated-JSON decode error, so callers can tell a
+// too-big payload apart from a malformed one.
+var ErrResponseTooLarge = errors.New("httpclient: response body exceeds MaxResponseBytes")
+
+// maxBytesReader fails with ErrResponseTooLarge as soon as more than
+// limit bytes have been read from r, instead of silently truncating.
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if limit := m.remaining + 1; int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		m.err = ErrResponseTooLarge
+		return n, m.err
+	}
+	if err != nil {
+		m.err = err
+	}
+	return n, err
+}
+
+// limitedBody returns the response body, wrapped to fail with
+// ErrResponseTooLarge once Client.MaxResponseBytes is exceeded. It
+// returns the body unwrapped when no limit is configured.
+func (rsp *Response) limitedBody() io.Reader {
+	limit := rsp.Request.Client.MaxResponseBytes
+	if limit <= 0 {
+		return rsp.Body
+	}
+	return &maxBytesReader{r: rsp.Body, remaining: limit}
+}
+
+// Bytes reads and returns the full response body, honoring
+// Client.MaxResponseBytes the same way JSON and WriteTo do.
+func (rsp *Response) Bytes() ([]byte, error) {
+	if rsp.Err != nil {
+		return nil, rsp.Err
+	}
+	defer rsp.Request.Cancel()
+	defer rsp.Body.Close()
+	if err := rsp.checkStatusCode(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rsp.limitedBody())
+}
+
+// JSONStream decodes a top-level JSON array one element at a time,
+// calling next for each element, so a crawler can stream a large
+// search result page without allocating the whole slice up front. It
+// honors Client.MaxResponseBytes the same way JSON does.
+func (rsp *Response) JSONStream(next func(json.RawMessage) error) error {
+	if rsp.Err != nil {
+		return rsp.Err
+	}
+	defer rsp.Request.Cancel()
+	defer rsp.Body.Close()
+	if err := rsp.checkStatusCode(); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(rsp.limitedBody())
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := next(raw); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
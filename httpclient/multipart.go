@@ -0,0 +1,135 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// FileField is a single file part to send with PostMultipart.
+type FileField struct {
+	FieldName   string
+	FileName    string
+	Content     io.Reader
+	ContentType string
+}
+
+// PostMultipart creates a HTTP POST request with a multipart/form-data
+// body built from fields and files. The body is streamed through a
+// pipe into an io.Reader instead of being buffered in memory, so large
+// files don't need to be read up front. The request's context
+// canceling closes the pipe from the writer side too, so the writer
+// goroutine never outlives a timed-out request. That watcher is armed
+// from bodyCancel, at Invoke time, so it observes whichever context
+// chained calls such as WithTimeout end up installing instead of
+// racing them.
+//
+// Unlike bytes.Reader/strings.Reader bodies, a pipe is single-use, so
+// net/http never populates req.GetBody for it. GetBody is wired up
+// here instead, rebuilding a fresh pipe and writer goroutine on every
+// call, so Retry can replay the request without reusing an
+// already-closed pipe. That requires re-reading each FileField's
+// Content, so any file whose Content implements io.Seeker is rewound
+// before the rebuild; non-seekable content can only be sent once and
+// GetBody reports that as an error if a retry is attempted.
+func (c *Client) PostMultipart(u string, fields url.Values, files []FileField) *Request {
+	mw0 := multipart.NewWriter(io.Discard)
+	boundary := mw0.Boundary()
+	contentType := mw0.FormDataContentType()
+
+	newBody := func(ctx context.Context) *io.PipeReader {
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		// boundary came from mw0.Boundary(), so it always satisfies
+		// multipart's boundary syntax; SetBoundary cannot fail here.
+		_ = mw.SetBoundary(boundary)
+		go func() {
+			err := writeMultipartBody(mw, fields, files)
+			if cerr := mw.Close(); err == nil {
+				err = cerr
+			}
+			pw.CloseWithError(err)
+		}()
+		if ctx != nil {
+			go func() {
+				<-ctx.Done()
+				pw.CloseWithError(ctx.Err())
+			}()
+		}
+		return pr
+	}
+
+	pr := newBody(nil)
+	req := c.makeRequest("POST", u, pr)
+	if req.Err != nil {
+		pr.Close()
+		return req
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	req.bodyCancel = func() {
+		ctx := req.Request.Context()
+		go func() {
+			<-ctx.Done()
+			pr.CloseWithError(ctx.Err())
+		}()
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		for _, f := range files {
+			s, ok := f.Content.(io.Seeker)
+			if !ok {
+				return nil, fmt.Errorf("httpclient: cannot retry multipart field %q: content is not seekable", f.FieldName)
+			}
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		return newBody(req.Request.Context()), nil
+	}
+
+	return req
+}
+
+func writeMultipartBody(mw *multipart.Writer, fields url.Values, files []FileField) error {
+	for k, vs := range fields {
+		for _, v := range vs {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := createFormFile(mw, f.FieldName, f.FileName, contentType)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, f.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFormFile is like multipart.Writer.CreateFormFile, but lets
+// the caller set the part's Content-Type instead of always using
+// application/octet-stream.
+func createFormFile(mw *multipart.Writer, fieldName, fileName, contentType string) (io.Writer, error) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(fieldName), escapeQuotes(fileName)))
+	h.Set("Content-Type", contentType)
+	return mw.CreatePart(h)
+}
+
+func escapeQuotes(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
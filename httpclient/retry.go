@@ -0,0 +1,195 @@
+package httpclient
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Retry configures automatic retries for transient HTTP failures such
+// as network errors, 429, 502, 503 and 504 responses.
+type Retry struct {
+	// MaxAttempts is the total number of attempts, including the
+	// first one. Zero or negative disables retrying.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the computed delay between retries. Zero means
+	// uncapped.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after each attempt. Defaults to 2
+	// when zero.
+	Multiplier float64
+	// Jitter is the fraction, in [0, 1], of the computed backoff that
+	// is randomized away to avoid thundering herds.
+	Jitter float64
+	// RetryOn decides whether a response/error pair should be
+	// retried. Defaults to retrying on network errors and 429, 502,
+	// 503, 504 responses.
+	RetryOn func(*http.Response, error) bool
+}
+
+// RateLimit throttles outgoing requests per host using a token
+// bucket, so multiple crawlers sharing a *Client don't hammer the
+// same site.
+type RateLimit struct {
+	// Rate is the sustained number of requests per second allowed for
+	// a single host.
+	Rate float64
+	// Burst is the maximum number of requests allowed to happen at
+	// once.
+	Burst int
+}
+
+func defaultRetryOn(rsp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (r *Retry) retryOn(rsp *http.Response, err error) bool {
+	if r.RetryOn != nil {
+		return r.RetryOn(rsp, err)
+	}
+	return defaultRetryOn(rsp, err)
+}
+
+func (r *Retry) backoff(attempt int) time.Duration {
+	mul := r.Multiplier
+	if mul <= 0 {
+		mul = 2
+	}
+	d := float64(r.InitialBackoff) * math.Pow(mul, float64(attempt))
+	if r.MaxBackoff > 0 && d > float64(r.MaxBackoff) {
+		d = float64(r.MaxBackoff)
+	}
+	if r.Jitter > 0 {
+		d -= d * r.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a
+// number of seconds or an HTTP-date, as described in RFC 7231 §7.1.3.
+func parseRetryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// hostLimiter keeps one rate.Limiter per request host, so a shared
+// *Client rate-limits each site independently.
+type hostLimiter struct {
+	rate  rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newHostLimiter(r rate.Limit, burst int) *hostLimiter {
+	return &hostLimiter{
+		rate:     r,
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (h *hostLimiter) wait(req *http.Request) error {
+	host := req.URL.Hostname()
+	h.mu.Lock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(h.rate, h.burst)
+		h.limiters[host] = l
+	}
+	h.mu.Unlock()
+	return l.Wait(req.Context())
+}
+
+// retryTransport wraps an http.RoundTripper with retry-with-backoff
+// and per-host rate limiting.
+type retryTransport struct {
+	next    http.RoundTripper
+	retry   Retry
+	limiter *hostLimiter
+}
+
+// RoundTrip implements http.RoundTripper. The request's own context
+// deadline still aborts the whole retry chain: a canceled context
+// stops the wait between attempts and is returned as the error.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	maxAttempts := t.retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	var (
+		rsp *http.Response
+		err error
+	)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return nil, berr
+			}
+			req.Body = body
+		}
+		if t.limiter != nil {
+			if werr := t.limiter.wait(req); werr != nil {
+				return nil, werr
+			}
+		}
+		rsp, err = next.RoundTrip(req)
+		if !t.retry.retryOn(rsp, err) || attempt == maxAttempts-1 {
+			return rsp, err
+		}
+		wait := t.retry.backoff(attempt)
+		if rsp != nil {
+			if d, ok := parseRetryAfter(rsp.Header); ok {
+				wait = d
+			}
+			io.Copy(io.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return rsp, err
+}